@@ -8,13 +8,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
 	"path"
 	"regexp"
 	"strings"
 	"time"
-	"unicode"
 
 	"github.com/Unknwon/com"
+	"github.com/go-xorm/builder"
 	"github.com/go-xorm/xorm"
 
 	api "github.com/gogits/go-gogs-client"
@@ -28,17 +29,26 @@ import (
 type ActionType int
 
 const (
-	CREATE_REPO         ActionType = iota + 1 // 1
-	RENAME_REPO                               // 2
-	STAR_REPO                                 // 3
-	FOLLOW_REPO                               // 4
-	COMMIT_REPO                               // 5
-	CREATE_ISSUE                              // 6
-	CREATE_PULL_REQUEST                       // 7
-	TRANSFER_REPO                             // 8
-	PUSH_TAG                                  // 9
-	COMMENT_ISSUE                             // 10
-	MERGE_PULL_REQUEST                        // 11
+	CREATE_REPO          ActionType = iota + 1 // 1
+	RENAME_REPO                                // 2
+	STAR_REPO                                  // 3
+	FOLLOW_REPO                                // 4
+	COMMIT_REPO                                // 5
+	CREATE_ISSUE                               // 6
+	CREATE_PULL_REQUEST                        // 7
+	TRANSFER_REPO                              // 8
+	PUSH_TAG                                   // 9
+	COMMENT_ISSUE                              // 10
+	MERGE_PULL_REQUEST                         // 11
+	DELETE_TAG                                 // 12
+	DELETE_BRANCH                              // 13
+	FORK_REPO                                  // 14
+	MIRROR_SYNC_PUSH                           // 15
+	MIRROR_SYNC_CREATE                         // 16
+	MIRROR_SYNC_DELETE                         // 17
+	APPROVE_PULL_REQUEST                       // 18
+	REJECT_PULL_REQUEST                        // 19
+	COMMENT_PULL_REQUEST                       // 20
 )
 
 var (
@@ -49,38 +59,126 @@ var (
 	// Same as Github. See https://help.github.com/articles/closing-issues-via-commit-messages
 	IssueCloseKeywords  = []string{"close", "closes", "closed", "fix", "fixes", "fixed", "resolve", "resolves", "resolved"}
 	IssueReopenKeywords = []string{"reopen", "reopens", "reopened"}
-
-	IssueCloseKeywordsPat, IssueReopenKeywordsPat *regexp.Regexp
-	IssueReferenceKeywordsPat                     *regexp.Regexp
 )
 
-func assembleKeywordsPattern(words []string) string {
-	return fmt.Sprintf(`(?i)(?:%s) \S+`, strings.Join(words, "|"))
-}
-
-func init() {
-	IssueCloseKeywordsPat = regexp.MustCompile(assembleKeywordsPattern(IssueCloseKeywords))
-	IssueReopenKeywordsPat = regexp.MustCompile(assembleKeywordsPattern(IssueReopenKeywords))
-	IssueReferenceKeywordsPat = regexp.MustCompile(`(?i)(?:)(^| )\S+`)
-}
-
 // Action represents user operation type and other information to repository.,
 // it implemented interface base.Actioner so that can be used in template render.
 type Action struct {
-	ID           int64 `xorm:"pk autoincr"`
-	UserID       int64 // Receiver user id.
+	ID           int64      `xorm:"pk autoincr"`
+	UserID       int64      // Receiver user id.
 	OpType       ActionType
 	ActUserID    int64  // Action user id.
 	ActUserName  string // Action user name.
 	ActEmail     string
 	ActAvatar    string `xorm:"-"`
-	RepoID       int64
+	RepoID       int64  `xorm:"INDEX(repo_activity)"`
 	RepoUserName string
 	RepoName     string
 	RefName      string
-	IsPrivate    bool      `xorm:"NOT NULL DEFAULT false"`
-	Content      string    `xorm:"TEXT"`
-	Created      time.Time `xorm:"created"`
+	IsPrivate    bool `xorm:"NOT NULL DEFAULT false"`
+	// Content holds the ActionPayload appropriate to OpType, JSON-marshalled.
+	// Rows with ContentVersion 0 either predate this encoding or carry no
+	// payload at all (e.g. CREATE_REPO, FORK_REPO); see GetPayload.
+	Content        string    `xorm:"TEXT"`
+	ContentVersion int       `xorm:"NOT NULL DEFAULT 0"`
+	Created        time.Time `xorm:"created INDEX(repo_activity)"`
+}
+
+// CurrentActionContentVersion is stamped onto every Action written through
+// SetPayload. Bump it if the JSON shape of a payload type changes in a way
+// older readers can't tolerate, and add a migration to match.
+const CurrentActionContentVersion = 1
+
+// ActionPayload is the structured data carried by an Action's Content column.
+// The concrete type is determined by OpType; see GetPayload.
+type ActionPayload interface{}
+
+// RenamePayload is the ActionPayload for RENAME_REPO.
+type RenamePayload struct {
+	OldRepoName string `json:"old_repo_name"`
+}
+
+// TransferPayload is the ActionPayload for TRANSFER_REPO.
+type TransferPayload struct {
+	OldOwnerName string `json:"old_owner_name"`
+	OldRepoName  string `json:"old_repo_name"`
+}
+
+// PushPayload is the ActionPayload for COMMIT_REPO, PUSH_TAG, DELETE_BRANCH,
+// DELETE_TAG, and MIRROR_SYNC_PUSH.
+type PushPayload struct {
+	Commits    []*PushCommit `json:"commits"`
+	CompareUrl string        `json:"compare_url"`
+}
+
+// RefPayload is the ActionPayload for MIRROR_SYNC_CREATE and MIRROR_SYNC_DELETE.
+type RefPayload struct {
+	RefType string `json:"ref_type"` // "branch" or "tag"
+}
+
+// IssuePayload is the ActionPayload for CREATE_ISSUE and COMMENT_ISSUE.
+type IssuePayload struct {
+	Index int64  `json:"index"`
+	Title string `json:"title"`
+}
+
+// MergePayload is the ActionPayload for MERGE_PULL_REQUEST.
+type MergePayload struct {
+	Index int64  `json:"index"`
+	Title string `json:"title"`
+}
+
+// ReviewPayload is the ActionPayload for APPROVE_PULL_REQUEST,
+// REJECT_PULL_REQUEST, and COMMENT_PULL_REQUEST.
+type ReviewPayload struct {
+	Index int64  `json:"index"`
+	Title string `json:"title"`
+}
+
+// SetPayload marshals p into Content and stamps the current content version.
+func (a *Action) SetPayload(p ActionPayload) error {
+	bs, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("Marshal: %v", err)
+	}
+	a.Content = string(bs)
+	a.ContentVersion = CurrentActionContentVersion
+	return nil
+}
+
+// GetPayload unmarshals Content into the concrete ActionPayload type that
+// corresponds to OpType. It only works for rows written by SetPayload;
+// rows with ContentVersion 0 use the legacy ad-hoc Content encoding and
+// are handled by their own accessors below (e.g. GetIssueInfos).
+func (a Action) GetPayload() (ActionPayload, error) {
+	if a.ContentVersion == 0 {
+		return nil, fmt.Errorf("action %d: content version 0 predates typed payloads", a.ID)
+	}
+
+	var payload ActionPayload
+	switch a.OpType {
+	case RENAME_REPO:
+		payload = new(RenamePayload)
+	case TRANSFER_REPO:
+		payload = new(TransferPayload)
+	case COMMIT_REPO, PUSH_TAG, DELETE_BRANCH, DELETE_TAG, MIRROR_SYNC_PUSH:
+		payload = new(PushPayload)
+	case MIRROR_SYNC_CREATE, MIRROR_SYNC_DELETE:
+		payload = new(RefPayload)
+	case CREATE_ISSUE, COMMENT_ISSUE:
+		payload = new(IssuePayload)
+	case MERGE_PULL_REQUEST:
+		payload = new(MergePayload)
+	case APPROVE_PULL_REQUEST, REJECT_PULL_REQUEST, COMMENT_PULL_REQUEST:
+		payload = new(ReviewPayload)
+	default:
+		return nil, fmt.Errorf("action %d: GetPayload does not support op type %d", a.ID, a.OpType)
+	}
+
+	if err := json.Unmarshal([]byte(a.Content), payload); err != nil {
+		return nil, fmt.Errorf("Unmarshal: %v", err)
+	}
+	return payload, nil
 }
 
 func (a *Action) AfterSet(colName string, _ xorm.Cell) {
@@ -125,6 +223,22 @@ func (a Action) GetBranch() string {
 	return a.RefName
 }
 
+// GetRefType returns "tag" or "branch" depending on which kind of ref this
+// action operated on. It is only meaningful for push/create/delete actions.
+func (a Action) GetRefType() string {
+	switch a.OpType {
+	case PUSH_TAG, DELETE_TAG:
+		return "tag"
+	case MIRROR_SYNC_CREATE, MIRROR_SYNC_DELETE:
+		if p, err := a.GetPayload(); err == nil {
+			if ref, ok := p.(*RefPayload); ok {
+				return ref.RefType
+			}
+		}
+	}
+	return "branch"
+}
+
 func (a Action) GetContent() string {
 	return a.Content
 }
@@ -134,6 +248,19 @@ func (a Action) GetCreate() time.Time {
 }
 
 func (a Action) GetIssueInfos() []string {
+	if a.ContentVersion > 0 {
+		if p, err := a.GetPayload(); err == nil {
+			switch payload := p.(type) {
+			case *IssuePayload:
+				return []string{fmt.Sprintf("%d", payload.Index), payload.Title}
+			case *MergePayload:
+				return []string{fmt.Sprintf("%d", payload.Index), payload.Title}
+			case *ReviewPayload:
+				return []string{fmt.Sprintf("%d", payload.Index), payload.Title}
+			}
+		}
+	}
+	// Legacy rows store "index|title" directly in Content.
 	return strings.SplitN(a.Content, "|", 2)
 }
 
@@ -170,6 +297,7 @@ func newRepoAction(e Engine, u *User, repo *Repository) (err error) {
 	}); err != nil {
 		return fmt.Errorf("notify watchers '%d/%d': %v", u.Id, repo.ID, err)
 	}
+	clearRepoActivityCache(repo.ID)
 
 	log.Trace("action.newRepoAction: %s/%s", u.Name, repo.Name)
 	return err
@@ -181,7 +309,7 @@ func NewRepoAction(u *User, repo *Repository) (err error) {
 }
 
 func renameRepoAction(e Engine, actUser *User, oldRepoName string, repo *Repository) (err error) {
-	if err = notifyWatchers(e, &Action{
+	act := &Action{
 		ActUserID:    actUser.Id,
 		ActUserName:  actUser.Name,
 		ActEmail:     actUser.Email,
@@ -190,8 +318,11 @@ func renameRepoAction(e Engine, actUser *User, oldRepoName string, repo *Reposit
 		RepoUserName: repo.Owner.Name,
 		RepoName:     repo.Name,
 		IsPrivate:    repo.IsPrivate,
-		Content:      oldRepoName,
-	}); err != nil {
+	}
+	if err = act.SetPayload(&RenamePayload{OldRepoName: oldRepoName}); err != nil {
+		return fmt.Errorf("SetPayload: %v", err)
+	}
+	if err = notifyWatchers(e, act); err != nil {
 		return fmt.Errorf("notify watchers: %v", err)
 	}
 
@@ -204,8 +335,120 @@ func RenameRepoAction(actUser *User, oldRepoName string, repo *Repository) error
 	return renameRepoAction(x, actUser, oldRepoName, repo)
 }
 
-func issueIndexTrimRight(c rune) bool {
-	return !unicode.IsDigit(c)
+// IssueRefAction is what a parsed issue/PR reference should do once resolved.
+type IssueRefAction int
+
+const (
+	ISSUE_REF_REFERENCE IssueRefAction = iota // Just leave a comment linking back.
+	ISSUE_REF_CLOSE                           // Close the issue/PR.
+	ISSUE_REF_REOPEN                          // Reopen the issue/PR.
+)
+
+// IssueReference is a single "#N", "owner/repo#N", or "!N" token found in a
+// commit message or PR description, together with the action its keyword
+// (if any) implies.
+type IssueReference struct {
+	OwnerName string // Empty means the pushing/current repository's owner.
+	RepoName  string // Empty means the pushing/current repository.
+	Index     int64
+	IsPull    bool // True when referenced via "!N".
+	Action    IssueRefAction
+}
+
+var issueRefTokenPat = regexp.MustCompile(`(?i)^([\w-]+(?:\.[\w-]+)*/[\w-]+(?:\.[\w-]+)*)?(#|!)(\d+)`)
+
+func parseIssueRefToken(field string) (IssueReference, bool) {
+	m := issueRefTokenPat.FindStringSubmatch(field)
+	if m == nil {
+		return IssueReference{}, false
+	}
+
+	ref := IssueReference{
+		Index:  com.StrTo(m[3]).MustInt64(),
+		IsPull: m[2] == "!",
+	}
+	if m[1] != "" {
+		parts := strings.SplitN(m[1], "/", 2)
+		ref.OwnerName, ref.RepoName = parts[0], parts[1]
+	}
+	return ref, true
+}
+
+// collectIssueRefList consumes a run of comma/"and"-separated reference
+// tokens starting at fields[i], e.g. the "#1, owner/other#2 and #3" in
+// "Fixes #1, owner/other#2 and #3". It stops at the first field that is
+// neither a separator nor a reference, and returns the index to resume
+// scanning from.
+func collectIssueRefList(fields []string, i int, action IssueRefAction) ([]IssueReference, int) {
+	var refs []IssueReference
+	for i < len(fields) {
+		if strings.EqualFold(fields[i], "and") || fields[i] == "," {
+			i++
+			continue
+		}
+
+		ref, ok := parseIssueRefToken(fields[i])
+		if !ok {
+			break
+		}
+		ref.Action = action
+		refs = append(refs, ref)
+		i++
+	}
+	return refs, i
+}
+
+func issueCloseKeywordSet() map[string]bool {
+	return issueKeywordSet(IssueCloseKeywords)
+}
+
+func issueReopenKeywordSet() map[string]bool {
+	return issueKeywordSet(IssueReopenKeywords)
+}
+
+func issueKeywordSet(words []string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
+
+var (
+	closeKeywordSet  = issueCloseKeywordSet()
+	reopenKeywordSet = issueReopenKeywordSet()
+)
+
+// ParseIssueReferences does a single pass over message and yields every
+// issue/PR reference it finds together with the action implied by any
+// close/reopen keyword that precedes it. It is called by the commit message
+// parser (updateIssuesCommit); pull request descriptions are not passed
+// through it, so "Closes #5" in a PR description does not close issue #5
+// on merge.
+func ParseIssueReferences(message string) []IssueReference {
+	fields := strings.Fields(message)
+
+	var refs []IssueReference
+	for i := 0; i < len(fields); {
+		word := strings.ToLower(strings.Trim(fields[i], ":"))
+		switch {
+		case closeKeywordSet[word]:
+			var found []IssueReference
+			found, i = collectIssueRefList(fields, i+1, ISSUE_REF_CLOSE)
+			refs = append(refs, found...)
+		case reopenKeywordSet[word]:
+			var found []IssueReference
+			found, i = collectIssueRefList(fields, i+1, ISSUE_REF_REOPEN)
+			refs = append(refs, found...)
+		default:
+			if ref, ok := parseIssueRefToken(fields[i]); ok {
+				ref.Action = ISSUE_REF_REFERENCE
+				refs = append(refs, ref)
+			}
+			i++
+		}
+	}
+	return refs
 }
 
 type PushCommit struct {
@@ -248,128 +491,95 @@ func (push *PushCommits) AvatarLink(email string) string {
 	return push.avatars[email]
 }
 
+// resolveIssueRef looks up the issue/PR a parsed IssueReference points at,
+// defaulting an unqualified reference to ownerName/repoName (the pushing
+// repository).
+// resolveIssueRef looks up the issue/PR ref points at. The bool return is
+// false when the reference should be silently skipped (unknown issue, or a
+// "!N" alias pointing at something that isn't actually a pull request).
+func resolveIssueRef(ref IssueReference, ownerName, repoName string) (*Issue, bool, error) {
+	owner, name := ref.OwnerName, ref.RepoName
+	if owner == "" {
+		owner, name = ownerName, repoName
+	}
+
+	issue, err := GetIssueByRef(fmt.Sprintf("%s/%s#%d", owner, name, ref.Index))
+	if err != nil {
+		if IsErrIssueNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if ref.IsPull && !issue.IsPull {
+		return nil, false, nil
+	}
+	return issue, true, nil
+}
+
 // updateIssuesCommit checks if issues are manipulated by commit message.
 func updateIssuesCommit(u *User, repo *Repository, repoUserName, repoName string, commits []*PushCommit) error {
 	// Commits are appended in the reverse order.
 	for i := len(commits) - 1; i >= 0; i-- {
 		c := commits[i]
 
-		refMarked := make(map[int64]bool)
-		for _, ref := range IssueReferenceKeywordsPat.FindAllString(c.Message, -1) {
-			ref = ref[strings.IndexByte(ref, byte(' '))+1:]
-			ref = strings.TrimRightFunc(ref, issueIndexTrimRight)
-
-			if len(ref) == 0 {
-				continue
-			}
-
-			// Add repo name if missing
-			if ref[0] == '#' {
-				ref = fmt.Sprintf("%s/%s%s", repoUserName, repoName, ref)
-			} else if !strings.Contains(ref, "/") {
-				// FIXME: We don't support User#ID syntax yet
-				// return ErrNotImplemented
-				continue
-			}
-
-			issue, err := GetIssueByRef(ref)
+		seen := make(map[[2]int64]bool) // keyed by (issueID, action)
+		for _, ref := range ParseIssueReferences(c.Message) {
+			issue, ok, err := resolveIssueRef(ref, repoUserName, repoName)
 			if err != nil {
-				if IsErrIssueNotExist(err) {
-					continue
-				}
 				return err
 			}
-
-			if refMarked[issue.ID] {
+			if !ok {
 				continue
 			}
-			refMarked[issue.ID] = true
-
-			url := fmt.Sprintf("%s/%s/%s/commit/%s", setting.AppSubUrl, repoUserName, repoName, c.Sha1)
-			message := fmt.Sprintf(`<a href="%s">%s</a>`, url, c.Message)
-			if err = CreateRefComment(u, repo, issue, message, c.Sha1); err != nil {
-				return err
-			}
-		}
-
-		refMarked = make(map[int64]bool)
-		// FIXME: can merge this one and next one to a common function.
-		for _, ref := range IssueCloseKeywordsPat.FindAllString(c.Message, -1) {
-			ref = ref[strings.IndexByte(ref, byte(' '))+1:]
-			ref = strings.TrimRightFunc(ref, issueIndexTrimRight)
 
-			if len(ref) == 0 {
-				continue
+			action := ref.Action
+			if action != ISSUE_REF_REFERENCE && issue.RepoID != repo.ID {
+				// Cross-repo close/reopen requires write access to the
+				// target repository; otherwise fall back to a reference.
+				targetRepo, err := GetRepositoryByID(issue.RepoID)
+				if err != nil {
+					return fmt.Errorf("GetRepositoryByID: %v", err)
+				}
+				hasWriteAccess, err := HasAccess(u, targetRepo, ACCESS_MODE_WRITE)
+				if err != nil {
+					return fmt.Errorf("HasAccess: %v", err)
+				}
+				if !hasWriteAccess {
+					action = ISSUE_REF_REFERENCE
+				}
 			}
 
-			// Add repo name if missing
-			if ref[0] == '#' {
-				ref = fmt.Sprintf("%s/%s%s", repoUserName, repoName, ref)
-			} else if !strings.Contains(ref, "/") {
-				// We don't support User#ID syntax yet
-				// return ErrNotImplemented
+			key := [2]int64{issue.ID, int64(action)}
+			if seen[key] {
 				continue
 			}
+			seen[key] = true
+
+			switch action {
+			case ISSUE_REF_REFERENCE:
+				url := fmt.Sprintf("%s/%s/%s/commit/%s", setting.AppSubUrl, repoUserName, repoName, c.Sha1)
+				message := fmt.Sprintf(`<a href="%s">%s</a>`, url, html.EscapeString(c.Message))
+				if err = CreateRefComment(u, repo, issue, message, c.Sha1); err != nil {
+					return err
+				}
 
-			issue, err := GetIssueByRef(ref)
-			if err != nil {
-				if IsErrIssueNotExist(err) {
+			case ISSUE_REF_CLOSE:
+				if issue.IsClosed {
 					continue
 				}
-				return err
-			}
-
-			if refMarked[issue.ID] {
-				continue
-			}
-			refMarked[issue.ID] = true
-
-			if issue.RepoID != repo.ID || issue.IsClosed {
-				continue
-			}
-
-			if err = issue.ChangeStatus(u, true); err != nil {
-				return err
-			}
-		}
-
-		// It is conflict to have close and reopen at same time, so refsMarkd doesn't need to reinit here.
-		for _, ref := range IssueReopenKeywordsPat.FindAllString(c.Message, -1) {
-			ref = ref[strings.IndexByte(ref, byte(' '))+1:]
-			ref = strings.TrimRightFunc(ref, issueIndexTrimRight)
-
-			if len(ref) == 0 {
-				continue
-			}
-
-			// Add repo name if missing
-			if ref[0] == '#' {
-				ref = fmt.Sprintf("%s/%s%s", repoUserName, repoName, ref)
-			} else if !strings.Contains(ref, "/") {
-				// We don't support User#ID syntax yet
-				// return ErrNotImplemented
-				continue
-			}
+				if err = issue.ChangeStatus(u, true); err != nil {
+					return err
+				}
+				clearRepoActivityCache(issue.RepoID)
 
-			issue, err := GetIssueByRef(ref)
-			if err != nil {
-				if IsErrIssueNotExist(err) {
+			case ISSUE_REF_REOPEN:
+				if !issue.IsClosed {
 					continue
 				}
-				return err
-			}
-
-			if refMarked[issue.ID] {
-				continue
-			}
-			refMarked[issue.ID] = true
-
-			if issue.RepoID != repo.ID || !issue.IsClosed {
-				continue
-			}
-
-			if err = issue.ChangeStatus(u, false); err != nil {
-				return err
+				if err = issue.ChangeStatus(u, false); err != nil {
+					return err
+				}
+				clearRepoActivityCache(issue.RepoID)
 			}
 		}
 	}
@@ -405,9 +615,18 @@ func CommitRepoAction(
 	}
 
 	isNewBranch := false
+	isDelRef := strings.HasPrefix(newCommitID, "0000000")
+	isTag := strings.HasPrefix(refFullName, "refs/tags/")
 	opType := COMMIT_REPO
-	// Check it's tag push or branch.
-	if strings.HasPrefix(refFullName, "refs/tags/") {
+	// Check it's tag push, branch push, or a ref deletion.
+	if isDelRef {
+		if isTag {
+			opType = DELETE_TAG
+		} else {
+			opType = DELETE_BRANCH
+		}
+		commit = &PushCommits{}
+	} else if isTag {
 		opType = PUSH_TAG
 		commit = &PushCommits{}
 	} else {
@@ -431,27 +650,26 @@ func CommitRepoAction(
 		commit.Commits = commit.Commits[:setting.FeedMaxCommitNum]
 	}
 
-	bs, err := json.Marshal(commit)
-	if err != nil {
-		return fmt.Errorf("Marshal: %v", err)
-	}
-
 	refName := git.RefEndName(refFullName)
 
-	if err = NotifyWatchers(&Action{
+	act := &Action{
 		ActUserID:    u.Id,
 		ActUserName:  userName,
 		ActEmail:     actEmail,
 		OpType:       opType,
-		Content:      string(bs),
 		RepoID:       repo.ID,
 		RepoUserName: repoUserName,
 		RepoName:     repoName,
 		RefName:      refName,
 		IsPrivate:    repo.IsPrivate,
-	}); err != nil {
+	}
+	if err = act.SetPayload(&PushPayload{Commits: commit.Commits, CompareUrl: commit.CompareUrl}); err != nil {
+		return fmt.Errorf("SetPayload: %v", err)
+	}
+	if err = NotifyWatchers(act); err != nil {
 		return fmt.Errorf("NotifyWatchers: %v", err)
 	}
+	clearRepoActivityCache(repo.ID)
 
 	payloadRepo := repo.ComposePayload()
 
@@ -521,13 +739,26 @@ func CommitRepoAction(
 			Repo:    payloadRepo,
 			Sender:  payloadSender,
 		})
+
+	case DELETE_BRANCH, DELETE_TAG:
+		refType := "branch"
+		if opType == DELETE_TAG {
+			refType = "tag"
+		}
+		return PrepareWebhooks(repo, HOOK_EVENT_DELETE, &api.DeletePayload{
+			Ref:        refName,
+			RefType:    refType,
+			PusherType: api.PUSHER_TYPE_USER,
+			Repo:       payloadRepo,
+			Sender:     payloadSender,
+		})
 	}
 
 	return nil
 }
 
 func transferRepoAction(e Engine, actUser, oldOwner, newOwner *User, repo *Repository) (err error) {
-	if err = notifyWatchers(e, &Action{
+	act := &Action{
 		ActUserID:    actUser.Id,
 		ActUserName:  actUser.Name,
 		ActEmail:     actUser.Email,
@@ -536,8 +767,11 @@ func transferRepoAction(e Engine, actUser, oldOwner, newOwner *User, repo *Repos
 		RepoUserName: newOwner.Name,
 		RepoName:     repo.Name,
 		IsPrivate:    repo.IsPrivate,
-		Content:      path.Join(oldOwner.LowerName, repo.LowerName),
-	}); err != nil {
+	}
+	if err = act.SetPayload(&TransferPayload{OldOwnerName: oldOwner.LowerName, OldRepoName: repo.LowerName}); err != nil {
+		return fmt.Errorf("SetPayload: %v", err)
+	}
+	if err = notifyWatchers(e, act); err != nil {
 		return fmt.Errorf("notify watchers '%d/%d': %v", actUser.Id, repo.ID, err)
 	}
 
@@ -557,18 +791,63 @@ func TransferRepoAction(actUser, oldOwner, newOwner *User, repo *Repository) err
 	return transferRepoAction(x, actUser, oldOwner, newOwner, repo)
 }
 
+func forkRepoAction(e Engine, actUser *User, repo *Repository, forkee *Repository) (err error) {
+	if err = notifyWatchers(e, &Action{
+		ActUserID:    actUser.Id,
+		ActUserName:  actUser.Name,
+		ActEmail:     actUser.Email,
+		OpType:       FORK_REPO,
+		RepoID:       repo.ID,
+		RepoUserName: repo.Owner.Name,
+		RepoName:     repo.Name,
+		IsPrivate:    repo.IsPrivate,
+	}); err != nil {
+		return fmt.Errorf("notify watchers '%d/%d': %v", actUser.Id, repo.ID, err)
+	}
+
+	if err = PrepareWebhooks(repo, HOOK_EVENT_FORK, &api.ForkPayload{
+		Forkee: forkee.ComposePayload(),
+		Repo:   repo.ComposePayload(),
+		Sender: &api.PayloadUser{
+			UserName:  actUser.Name,
+			ID:        actUser.Id,
+			AvatarUrl: setting.AppUrl + actUser.RelAvatarLink(),
+		},
+	}); err != nil {
+		return fmt.Errorf("PrepareWebhooks: %v", err)
+	}
+
+	log.Trace("action.forkRepoAction: %s/%s", actUser.Name, repo.Name)
+	return nil
+}
+
+// ForkRepoAction adds new action for forking a repository and fires the
+// matching webhook. Not yet called from the fork path itself (repository
+// creation/cloning lives outside this package); wire it in alongside that
+// work rather than here, to avoid guessing at that code's shape.
+func ForkRepoAction(actUser *User, repo *Repository, forkee *Repository) error {
+	return forkRepoAction(x, actUser, repo, forkee)
+}
+
 func mergePullRequestAction(e Engine, actUser *User, repo *Repository, pull *Issue) error {
-	return notifyWatchers(e, &Action{
+	act := &Action{
 		ActUserID:    actUser.Id,
 		ActUserName:  actUser.Name,
 		ActEmail:     actUser.Email,
 		OpType:       MERGE_PULL_REQUEST,
-		Content:      fmt.Sprintf("%d|%s", pull.Index, pull.Name),
 		RepoID:       repo.ID,
 		RepoUserName: repo.Owner.Name,
 		RepoName:     repo.Name,
 		IsPrivate:    repo.IsPrivate,
-	})
+	}
+	if err := act.SetPayload(&MergePayload{Index: pull.Index, Title: pull.Name}); err != nil {
+		return fmt.Errorf("SetPayload: %v", err)
+	}
+	if err := notifyWatchers(e, act); err != nil {
+		return err
+	}
+	clearRepoActivityCache(repo.ID)
+	return nil
 }
 
 // MergePullRequestAction adds new action for merging pull request.
@@ -576,13 +855,222 @@ func MergePullRequestAction(actUser *User, repo *Repository, pull *Issue) error
 	return mergePullRequestAction(x, actUser, repo, pull)
 }
 
-// GetFeeds returns action list of given user in given context.
-func GetFeeds(uid, offset int64, isProfile bool) ([]*Action, error) {
-	actions := make([]*Action, 0, 20)
-	sess := x.Limit(20, int(offset)).Desc("id").Where("user_id=?", uid)
-	if isProfile {
-		sess.And("is_private=?", false).And("act_user_id=?", uid)
+// mirrorSyncAction records a feed entry for a ref brought in by a mirror sync
+// job, re-using the same OpType family the matching manual operation would
+// have produced (push, create, delete).
+func mirrorSyncAction(e Engine, opType ActionType, repo *Repository, refName string, payload ActionPayload) error {
+	act := &Action{
+		ActUserID:    repo.Owner.Id,
+		ActUserName:  repo.Owner.Name,
+		ActEmail:     repo.Owner.Email,
+		OpType:       opType,
+		RepoID:       repo.ID,
+		RepoUserName: repo.Owner.Name,
+		RepoName:     repo.Name,
+		RefName:      refName,
+		IsPrivate:    repo.IsPrivate,
+	}
+	if err := act.SetPayload(payload); err != nil {
+		return fmt.Errorf("SetPayload: %v", err)
+	}
+	if err := notifyWatchers(e, act); err != nil {
+		return err
+	}
+	clearRepoActivityCache(repo.ID)
+
+	payloadRepo := repo.ComposePayload()
+	payloadSender := &api.PayloadUser{
+		UserName:  repo.Owner.Name,
+		ID:        repo.Owner.Id,
+		AvatarUrl: setting.AppUrl + repo.Owner.RelAvatarLink(),
 	}
-	err := sess.Find(&actions)
+
+	switch p := payload.(type) {
+	case *PushPayload:
+		commits := make([]*api.PayloadCommit, len(p.Commits))
+		for i, cmt := range p.Commits {
+			authorUserName := ""
+			if author, err := GetUserByEmail(cmt.AuthorEmail); err == nil {
+				authorUserName = author.Name
+			}
+			commits[i] = &api.PayloadCommit{
+				ID:      cmt.Sha1,
+				Message: cmt.Message,
+				URL:     fmt.Sprintf("%s/commit/%s", repo.RepoLink(), cmt.Sha1),
+				Author: &api.PayloadAuthor{
+					Name:     cmt.AuthorName,
+					Email:    cmt.AuthorEmail,
+					UserName: authorUserName,
+				},
+			}
+		}
+		return PrepareWebhooks(repo, HOOK_EVENT_PUSH, &api.PushPayload{
+			Ref:        "refs/heads/" + refName,
+			CompareUrl: setting.AppUrl + p.CompareUrl,
+			Commits:    commits,
+			Repo:       payloadRepo,
+			Pusher: &api.PayloadAuthor{
+				Name:     repo.Owner.DisplayName(),
+				Email:    repo.Owner.Email,
+				UserName: repo.Owner.Name,
+			},
+			Sender: payloadSender,
+		})
+
+	case *RefPayload:
+		switch opType {
+		case MIRROR_SYNC_CREATE:
+			return PrepareWebhooks(repo, HOOK_EVENT_CREATE, &api.CreatePayload{
+				Ref:     refName,
+				RefType: p.RefType,
+				Repo:    payloadRepo,
+				Sender:  payloadSender,
+			})
+		case MIRROR_SYNC_DELETE:
+			return PrepareWebhooks(repo, HOOK_EVENT_DELETE, &api.DeletePayload{
+				Ref:        refName,
+				RefType:    p.RefType,
+				PusherType: api.PUSHER_TYPE_USER,
+				Repo:       payloadRepo,
+				Sender:     payloadSender,
+			})
+		}
+	}
+
+	return nil
+}
+
+// MirrorSyncPushAction adds new action for a push brought in by mirror sync.
+// Not yet called from the mirror update job itself, which lives outside
+// this package; the job should call this once a sync pulls in new commits.
+func MirrorSyncPushAction(repo *Repository, refName string, commit *PushCommits) error {
+	return mirrorSyncAction(x, MIRROR_SYNC_PUSH, repo, refName, &PushPayload{Commits: commit.Commits, CompareUrl: commit.CompareUrl})
+}
+
+// MirrorSyncCreateAction adds new action for a branch or tag created by
+// mirror sync. Not yet called from the mirror update job; see
+// MirrorSyncPushAction.
+func MirrorSyncCreateAction(repo *Repository, refName, refType string) error {
+	return mirrorSyncAction(x, MIRROR_SYNC_CREATE, repo, refName, &RefPayload{RefType: refType})
+}
+
+// MirrorSyncDeleteAction adds new action for a branch or tag deleted by
+// mirror sync. Not yet called from the mirror update job; see
+// MirrorSyncPushAction.
+func MirrorSyncDeleteAction(repo *Repository, refName, refType string) error {
+	return mirrorSyncAction(x, MIRROR_SYNC_DELETE, repo, refName, &RefPayload{RefType: refType})
+}
+
+// reviewPullRequestAction records a feed entry for a pull request review.
+// Unlike the push/create/delete/fork actions above, this intentionally does
+// not call PrepareWebhooks: there is no HOOK_EVENT_* for pull request
+// reviews in this codebase's webhook payload set (api.*Payload only covers
+// push/create/delete/fork/pull-request-open-or-close), so notifyWatchers is
+// the only notification a review produces today.
+func reviewPullRequestAction(e Engine, doer *User, repo *Repository, pull *Issue, opType ActionType) error {
+	act := &Action{
+		ActUserID:    doer.Id,
+		ActUserName:  doer.Name,
+		ActEmail:     doer.Email,
+		OpType:       opType,
+		RepoID:       repo.ID,
+		RepoUserName: repo.Owner.Name,
+		RepoName:     repo.Name,
+		IsPrivate:    repo.IsPrivate,
+	}
+	if err := act.SetPayload(&ReviewPayload{Index: pull.Index, Title: pull.Name}); err != nil {
+		return fmt.Errorf("SetPayload: %v", err)
+	}
+	if err := notifyWatchers(e, act); err != nil {
+		return err
+	}
+	clearRepoActivityCache(repo.ID)
+	return nil
+}
+
+// ApprovePullRequestAction adds new action for approving a pull request via
+// a review. Not yet bound to review submission, which lives outside this
+// package; that handler should call this once a review is persisted.
+func ApprovePullRequestAction(doer *User, repo *Repository, pull *Issue) error {
+	return reviewPullRequestAction(x, doer, repo, pull, APPROVE_PULL_REQUEST)
+}
+
+// RejectPullRequestAction adds new action for requesting changes on a pull
+// request via a review. Not yet bound to review submission; see
+// ApprovePullRequestAction.
+func RejectPullRequestAction(doer *User, repo *Repository, pull *Issue) error {
+	return reviewPullRequestAction(x, doer, repo, pull, REJECT_PULL_REQUEST)
+}
+
+// CommentPullRequestAction adds new action for commenting on a pull request
+// via a review. Not yet bound to review submission; see
+// ApprovePullRequestAction.
+func CommentPullRequestAction(doer *User, repo *Repository, pull *Issue) error {
+	return reviewPullRequestAction(x, doer, repo, pull, COMMENT_PULL_REQUEST)
+}
+
+// FindActionsOptions describes the filters accepted by FindActions. Zero
+// values are treated as "no filter" except PageSize/Page, which default to
+// 20 and 1 respectively, and IncludePrivate, whose zero value hides
+// private-repository actions other than RequestingUserID's own.
+type FindActionsOptions struct {
+	UserID           int64 // Receiver user ID; whose feed this is.
+	RequestingUserID int64 // Who is looking; 0 means anonymous.
+	ActorID          int64 // Only actions performed by this user.
+	RepoID           int64 // Only actions against this repository.
+	Types            []ActionType
+	IncludePrivate   bool // Include actions against private repositories.
+	Since, Before    time.Time
+	Page, PageSize   int
+}
+
+func (opts FindActionsOptions) toConds() builder.Cond {
+	cond := builder.NewCond()
+	if opts.UserID > 0 {
+		cond = cond.And(builder.Eq{"user_id": opts.UserID})
+	}
+	if opts.ActorID > 0 {
+		cond = cond.And(builder.Eq{"act_user_id": opts.ActorID})
+	}
+	if opts.RepoID > 0 {
+		cond = cond.And(builder.Eq{"repo_id": opts.RepoID})
+	}
+	if len(opts.Types) > 0 {
+		cond = cond.And(builder.In("op_type", opts.Types))
+	}
+	if !opts.IncludePrivate {
+		// The requesting user can always see their own actions, private or not.
+		visible := builder.Eq{"is_private": false}
+		if opts.RequestingUserID > 0 {
+			cond = cond.And(builder.Or(visible, builder.Eq{"act_user_id": opts.RequestingUserID}))
+		} else {
+			cond = cond.And(visible)
+		}
+	}
+	if !opts.Since.IsZero() {
+		cond = cond.And(builder.Gte{"created": opts.Since})
+	}
+	if !opts.Before.IsZero() {
+		cond = cond.And(builder.Lte{"created": opts.Before})
+	}
+	return cond
+}
+
+// FindActions returns actions matching opts, newest first. It replaces the
+// old GetFeeds(uid, offset, isProfile); in this package its only caller is
+// GetFeedsAtom.
+func FindActions(opts FindActionsOptions) ([]*Action, error) {
+	if opts.PageSize <= 0 {
+		opts.PageSize = 20
+	}
+	if opts.Page <= 0 {
+		opts.Page = 1
+	}
+
+	actions := make([]*Action, 0, opts.PageSize)
+	err := x.Where(opts.toConds()).
+		Desc("id").
+		Limit(opts.PageSize, (opts.Page-1)*opts.PageSize).
+		Find(&actions)
 	return actions, err
 }
@@ -0,0 +1,162 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+
+	"github.com/gorilla/feeds"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+// FeedContext describes which actions GetFeedsAtom should render and how to
+// label the resulting document. It mirrors FindActionsOptions rather than
+// wrapping it directly so callers don't have to know FindActions' column
+// names to build a feed.
+type FeedContext struct {
+	Title            string // Feed <title>, e.g. the user's display name.
+	Link             string // Feed home page, e.g. the profile or repo URL.
+	UserID           int64  // Whose feed this is.
+	RequestingUserID int64  // Who is subscribing; 0 for an anonymous reader.
+	RepoID           int64  // Non-zero to scope the feed to one repository.
+	IsProfile        bool   // Profile feed: only UserID's own actions against public repositories.
+	PageSize         int
+}
+
+// GetFeedsAtom renders the same action list the HTML templates use as an
+// Atom 1.0 document. It honours IsPrivate and IsProfile exactly the way the
+// HTML path does, so private-repo actions never leak into an
+// unauthenticated feed.
+func GetFeedsAtom(ctx FeedContext) (*feeds.Feed, error) {
+	opts := FindActionsOptions{
+		UserID:           ctx.UserID,
+		RequestingUserID: ctx.RequestingUserID,
+		RepoID:           ctx.RepoID,
+		PageSize:         ctx.PageSize,
+	}
+	if ctx.IsProfile {
+		opts.ActorID = ctx.UserID
+		// Public only, even when the user reads their own profile feed.
+		opts.RequestingUserID = 0
+	} else {
+		opts.IncludePrivate = ctx.RequestingUserID == ctx.UserID
+	}
+
+	actions, err := FindActions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("FindActions: %v", err)
+	}
+
+	feed := &feeds.Feed{
+		Title: ctx.Title,
+		Link:  &feeds.Link{Href: ctx.Link},
+	}
+	if len(actions) > 0 {
+		feed.Updated = actions[0].Created
+	}
+
+	feed.Items = make([]*feeds.Item, 0, len(actions))
+	for _, a := range actions {
+		item, ok := actionFeedItem(a)
+		if !ok {
+			continue
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	return feed, nil
+}
+
+// actionFeedItem renders the Atom entry for a single Action. The bool
+// return is false for OpTypes this feed doesn't know how to describe, and
+// for actions whose Content cannot be decoded by GetPayload.
+func actionFeedItem(a *Action) (*feeds.Item, bool) {
+	title, ok := actionFeedTitle(a)
+	if !ok {
+		return nil, false
+	}
+
+	return &feeds.Item{
+		Id:          fmt.Sprintf("%s/feeds/action/%d", setting.AppUrl, a.ID),
+		Title:       title,
+		Link:        &feeds.Link{Href: setting.AppUrl + a.GetRepoPath()},
+		Description: title,
+		Author:      &feeds.Author{Name: a.ActUserName, Email: a.ActEmail},
+		Created:     a.Created,
+	}, true
+}
+
+func actionFeedTitle(a *Action) (string, bool) {
+	switch a.OpType {
+	case CREATE_REPO:
+		return fmt.Sprintf("%s created repository %s", a.ActUserName, a.GetRepoPath()), true
+
+	case FORK_REPO:
+		return fmt.Sprintf("%s forked %s", a.ActUserName, a.GetRepoPath()), true
+
+	case RENAME_REPO:
+		p, err := a.GetPayload()
+		if err != nil {
+			return "", false
+		}
+		rp := p.(*RenamePayload)
+		return fmt.Sprintf("%s renamed %s from %s", a.ActUserName, a.GetRepoPath(), rp.OldRepoName), true
+
+	case TRANSFER_REPO:
+		p, err := a.GetPayload()
+		if err != nil {
+			return "", false
+		}
+		tp := p.(*TransferPayload)
+		return fmt.Sprintf("%s transferred %s/%s to %s", a.ActUserName, tp.OldOwnerName, tp.OldRepoName, a.GetRepoPath()), true
+
+	case COMMIT_REPO, MIRROR_SYNC_PUSH:
+		p, err := a.GetPayload()
+		if err != nil {
+			return "", false
+		}
+		push := p.(*PushPayload)
+		return fmt.Sprintf("%s pushed %d commit(s) to %s", a.ActUserName, len(push.Commits), a.GetBranch()), true
+
+	case PUSH_TAG, MIRROR_SYNC_CREATE:
+		return fmt.Sprintf("%s created %s %s at %s", a.ActUserName, a.GetRefType(), a.GetBranch(), a.GetRepoPath()), true
+
+	case DELETE_BRANCH, DELETE_TAG, MIRROR_SYNC_DELETE:
+		return fmt.Sprintf("%s deleted %s %s at %s", a.ActUserName, a.GetRefType(), a.GetBranch(), a.GetRepoPath()), true
+
+	case CREATE_ISSUE:
+		return fmt.Sprintf("%s opened issue %s#%s", a.ActUserName, a.GetRepoPath(), a.GetIssueInfos()[0]), true
+
+	case COMMENT_ISSUE:
+		return fmt.Sprintf("%s commented on issue %s#%s", a.ActUserName, a.GetRepoPath(), a.GetIssueInfos()[0]), true
+
+	case CREATE_PULL_REQUEST:
+		return fmt.Sprintf("%s opened pull request %s#%s", a.ActUserName, a.GetRepoPath(), a.GetIssueInfos()[0]), true
+
+	case MERGE_PULL_REQUEST:
+		p, err := a.GetPayload()
+		if err != nil {
+			return "", false
+		}
+		mp := p.(*MergePayload)
+		return fmt.Sprintf("%s merged pull request #%d %s", a.ActUserName, mp.Index, mp.Title), true
+
+	case APPROVE_PULL_REQUEST, REJECT_PULL_REQUEST, COMMENT_PULL_REQUEST:
+		p, err := a.GetPayload()
+		if err != nil {
+			return "", false
+		}
+		rp := p.(*ReviewPayload)
+		verb := map[ActionType]string{
+			APPROVE_PULL_REQUEST: "approved",
+			REJECT_PULL_REQUEST:  "requested changes on",
+			COMMENT_PULL_REQUEST: "commented on",
+		}[a.OpType]
+		return fmt.Sprintf("%s %s pull request #%d %s", a.ActUserName, verb, rp.Index, rp.Title), true
+	}
+
+	return "", false
+}
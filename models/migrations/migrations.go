@@ -0,0 +1,82 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/go-xorm/xorm"
+
+	"github.com/gogits/gogs/modules/log"
+)
+
+// Migration describes a single, one-shot schema or data migration.
+type Migration interface {
+	Description() string
+	Migrate(*xorm.Engine) error
+}
+
+type migration struct {
+	description string
+	migrate     func(*xorm.Engine) error
+}
+
+// NewMigration creates a new migration.
+func NewMigration(desc string, fn func(*xorm.Engine) error) Migration {
+	return &migration{desc, fn}
+}
+
+func (m *migration) Description() string {
+	return m.description
+}
+
+func (m *migration) Migrate(x *xorm.Engine) error {
+	return m.migrate(x)
+}
+
+// This is a sequence of migrations. Add new migrations to the bottom of the
+// list. Once the commit is released, do not modify earlier migrations.
+var migrations = []Migration{
+	NewMigration("migrate action content to typed, versioned payloads", migrateActionContentToPayloads),
+	NewMigration("add repo_activity index to action table", addRepoActivityIndex),
+}
+
+// Migrate runs any migration that has not yet recorded itself as applied.
+func Migrate(x *xorm.Engine) error {
+	if err := x.Sync(new(Version)); err != nil {
+		return fmt.Errorf("sync version: %v", err)
+	}
+
+	currentVersion := new(Version)
+	has, err := x.Get(currentVersion)
+	if err != nil {
+		return fmt.Errorf("get current version: %v", err)
+	} else if !has {
+		currentVersion.ID = 0
+		currentVersion.Version = 0
+		if _, err = x.InsertOne(currentVersion); err != nil {
+			return fmt.Errorf("insert version: %v", err)
+		}
+	}
+
+	v := currentVersion.Version
+	for i, m := range migrations[v:] {
+		log.Info("Migration[%d]: %s", v+int64(i), m.Description())
+		if err = m.Migrate(x); err != nil {
+			return fmt.Errorf("migration[%d]: %s failed: %v", v+int64(i), m.Description(), err)
+		}
+		currentVersion.Version = v + int64(i) + 1
+		if _, err = x.Id(1).Update(currentVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Version tracks how many migrations have run against this database.
+type Version struct {
+	ID      int64 `xorm:"pk autoincr"`
+	Version int64
+}
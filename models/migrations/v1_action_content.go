@@ -0,0 +1,205 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-xorm/xorm"
+)
+
+// actionV1 mirrors the handful of models.Action columns this migration
+// touches. It is kept local (rather than importing models) to avoid a
+// migrations <-> models import cycle.
+type actionV1 struct {
+	ID             int64 `xorm:"pk autoincr"`
+	OpType         int
+	Content        string `xorm:"TEXT"`
+	ContentVersion int    `xorm:"NOT NULL DEFAULT 0"`
+}
+
+func (actionV1) TableName() string {
+	return "action"
+}
+
+// These mirror the ActionType values in models/action.go that carried a
+// legacy, ad-hoc encoded Content before ActionPayload existed.
+const (
+	opRenameRepo     = 2
+	opCommitRepo     = 5
+	opCreateIssue    = 6
+	opTransferRepo   = 8
+	opPushTag        = 9
+	opCommentIssue   = 10
+	opMergePullReq   = 11
+	opApprovePullReq = 18
+	opRejectPullReq  = 19
+	opCommentPullReq = 20
+)
+
+// legacyPushCommit mirrors models.PushCommit. It has no JSON tags in either
+// version, so its encoding hasn't changed; only the wrapper around it has.
+type legacyPushCommit struct {
+	Sha1        string
+	Message     string
+	AuthorEmail string
+	AuthorName  string
+}
+
+// legacyPushCommits mirrors the pre-ActionPayload models.PushCommits, which
+// is what CommitRepoAction used to json.Marshal straight into Content for
+// COMMIT_REPO/PUSH_TAG.
+type legacyPushCommits struct {
+	Len        int
+	Commits    []*legacyPushCommit
+	CompareUrl string
+}
+
+func migrateActionContentToPayloads(x *xorm.Engine) error {
+	if err := x.Sync(new(actionV1)); err != nil {
+		return fmt.Errorf("sync: %v", err)
+	}
+
+	// Page by ID rather than "WHERE content_version = 0 LIMIT n OFFSET m":
+	// some actions (CREATE_REPO, STAR_REPO, FOLLOW_REPO, CREATE_PULL_REQUEST)
+	// carry no Content migrateActionContent knows how to convert, so they're
+	// left at content_version 0 forever, and an offset into that same
+	// condition would never advance past them.
+	const batchSize = 100
+	var lastID int64
+	for {
+		actions := make([]*actionV1, 0, batchSize)
+		if err := x.Where("content_version = 0 AND id > ?", lastID).OrderBy("id").Limit(batchSize).Find(&actions); err != nil {
+			return fmt.Errorf("find actions: %v", err)
+		}
+		if len(actions) == 0 {
+			return nil
+		}
+
+		sess := x.NewSession()
+		if err := migrateActionBatch(sess, actions); err != nil {
+			sess.Close()
+			return err
+		}
+		sess.Close()
+
+		lastID = actions[len(actions)-1].ID
+	}
+}
+
+func migrateActionBatch(sess *xorm.Session, actions []*actionV1) error {
+	for _, a := range actions {
+		content, ok := migrateActionContent(a.OpType, a.Content)
+		if !ok {
+			continue
+		}
+		a.Content = content
+		a.ContentVersion = 1
+		if _, err := sess.Id(a.ID).Cols("content", "content_version").Update(a); err != nil {
+			return fmt.Errorf("update action %d: %v", a.ID, err)
+		}
+	}
+	return nil
+}
+
+// migrateActionContent converts one of this table's legacy Content
+// encodings into the JSON shape the corresponding models.ActionPayload now
+// expects. It reports false when opType has no legacy encoding (e.g. it was
+// introduced alongside ActionPayload itself, like DELETE_BRANCH or
+// MIRROR_SYNC_PUSH, and so never had a pre-migration row) or the legacy
+// content doesn't parse, leaving the row for manual inspection.
+func migrateActionContent(opType int, legacy string) (string, bool) {
+	switch opType {
+	case opCreateIssue, opCommentIssue, opMergePullReq, opApprovePullReq, opRejectPullReq, opCommentPullReq:
+		return migrateIssueRefContent(legacy)
+	case opRenameRepo:
+		return migrateRenameRepoContent(legacy)
+	case opTransferRepo:
+		return migrateTransferRepoContent(legacy)
+	case opCommitRepo, opPushTag:
+		return migratePushContent(legacy)
+	default:
+		return "", false
+	}
+}
+
+// migrateIssueRefContent converts a legacy "index|title"-encoded Content
+// into the JSON shape used by models.IssuePayload/MergePayload/ReviewPayload
+// (they all share the same Index/Title shape).
+func migrateIssueRefContent(legacy string) (string, bool) {
+	parts := strings.SplitN(legacy, "|", 2)
+	index, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", false
+	}
+	title := ""
+	if len(parts) > 1 {
+		title = parts[1]
+	}
+
+	bs, err := json.Marshal(struct {
+		Index int64  `json:"index"`
+		Title string `json:"title"`
+	}{index, title})
+	if err != nil {
+		return "", false
+	}
+	return string(bs), true
+}
+
+// migrateRenameRepoContent converts a legacy RENAME_REPO Content, which was
+// just the raw old repository name, into the JSON shape of
+// models.RenamePayload.
+func migrateRenameRepoContent(legacy string) (string, bool) {
+	bs, err := json.Marshal(struct {
+		OldRepoName string `json:"old_repo_name"`
+	}{legacy})
+	if err != nil {
+		return "", false
+	}
+	return string(bs), true
+}
+
+// migrateTransferRepoContent converts a legacy TRANSFER_REPO Content, which
+// was path.Join(oldOwnerName, oldRepoName), into the JSON shape of
+// models.TransferPayload.
+func migrateTransferRepoContent(legacy string) (string, bool) {
+	parts := strings.SplitN(legacy, "/", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+
+	bs, err := json.Marshal(struct {
+		OldOwnerName string `json:"old_owner_name"`
+		OldRepoName  string `json:"old_repo_name"`
+	}{parts[0], parts[1]})
+	if err != nil {
+		return "", false
+	}
+	return string(bs), true
+}
+
+// migratePushContent converts a legacy COMMIT_REPO/PUSH_TAG Content —
+// json.Marshal of the pre-ActionPayload models.PushCommits, which had a Len
+// field and capitalized, untagged keys — into the JSON shape of
+// models.PushPayload.
+func migratePushContent(legacy string) (string, bool) {
+	var old legacyPushCommits
+	if err := json.Unmarshal([]byte(legacy), &old); err != nil {
+		return "", false
+	}
+
+	bs, err := json.Marshal(struct {
+		Commits    []*legacyPushCommit `json:"commits"`
+		CompareUrl string              `json:"compare_url"`
+	}{old.Commits, old.CompareUrl})
+	if err != nil {
+		return "", false
+	}
+	return string(bs), true
+}
@@ -0,0 +1,34 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-xorm/xorm"
+)
+
+// actionRepoActivityIndex mirrors the columns models.Action now indexes
+// together so GetRepoActivityStats can scan by repo_id/created without a
+// full table scan. Column order must match the Action struct's tags exactly
+// (xorm builds composite indexes in struct-declaration order). Kept local
+// to avoid a migrations <-> models import cycle, same as actionV1 in
+// v1_action_content.go.
+type actionRepoActivityIndex struct {
+	RepoID  int64     `xorm:"INDEX(repo_activity)"`
+	Created time.Time `xorm:"created INDEX(repo_activity)"`
+}
+
+func (actionRepoActivityIndex) TableName() string {
+	return "action"
+}
+
+func addRepoActivityIndex(x *xorm.Engine) error {
+	if err := x.Sync2(new(actionRepoActivityIndex)); err != nil {
+		return fmt.Errorf("sync repo_activity index: %v", err)
+	}
+	return nil
+}
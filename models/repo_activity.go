@@ -0,0 +1,208 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/Unknwon/com"
+	"github.com/go-xorm/builder"
+
+	"github.com/gogits/gogs/modules/cache"
+	"github.com/gogits/gogs/modules/log"
+)
+
+// repoActivityCacheTTL is how long a computed ActivityStats is trusted
+// before GetRepoActivityStats recomputes it from scratch, independent of
+// the explicit clearRepoActivityCache invalidation below.
+const repoActivityCacheTTL = 10 * 60 // 10 minutes, in seconds.
+
+// ActivityAuthorCommits is how many commits a single author pushed in the
+// window an ActivityStats covers.
+type ActivityAuthorCommits struct {
+	AuthorEmail string
+	AuthorName  string
+	Commits     int64
+}
+
+// ActivityStats is a bucketed summary of a repository's Action history,
+// built for dashboards that want totals rather than a raw feed.
+type ActivityStats struct {
+	ActiveCommits            []*ActivityAuthorCommits
+	OpenedIssueCount         int64
+	ClosedIssueCount         int64
+	UnresolvedIssueCount     int64 // Commented on, but still open.
+	OpenedPullRequestCount   int64
+	MergedPullRequestCount   int64
+	RejectedPullRequestCount int64
+	NewReleaseCount          int64 // Tags pushed.
+}
+
+func repoActivityCacheKey(repoID int64) string {
+	return fmt.Sprintf("repo_activity_stats_%d", repoID)
+}
+
+// clearRepoActivityCache invalidates every cached ActivityStats window for
+// repoID. It is called from the Action constructors in action.go that
+// record anything GetRepoActivityStats counts (push, tag, mirror sync,
+// merge, review), plus updateIssuesCommit's close/reopen branches, so the
+// next request recomputes. Recording CREATE_ISSUE, COMMENT_ISSUE or
+// CREATE_PULL_REQUEST actions does not call it, so those counts can lag by
+// up to repoActivityCacheTTL. ClosedIssueCount is still not
+// invalidated when an issue is closed from the UI's "Close issue" button
+// rather than a commit reference, since that path lives outside this
+// package; callers relying on a closed-issue count fresher than
+// repoActivityCacheTTL after such a close should call this too.
+func clearRepoActivityCache(repoID int64) {
+	cache.Remove(repoActivityCacheKey(repoID))
+}
+
+// GetRepoActivityStats summarizes repoID's Action history since timeFrom:
+// commits pushed per author, issues opened/closed/left unresolved, pull
+// requests opened/merged/rejected, and new release tags. The result is
+// cached under repoActivityCacheKey(repoID), keyed further by timeFrom so
+// two callers requesting different windows for the same repo (e.g. a "last
+// 7 days" vs. "last 30 days" dashboard filter) never hand back each other's
+// stats; clearRepoActivityCache drops every window for repoID at once,
+// since the cached windows aren't tracked anywhere else.
+func GetRepoActivityStats(repoID int64, timeFrom time.Time) (*ActivityStats, error) {
+	key := repoActivityCacheKey(repoID)
+	windows, _ := cache.Get(key).(map[int64]*ActivityStats)
+	if stats, ok := windows[timeFrom.Unix()]; ok {
+		return stats, nil
+	}
+
+	stats := &ActivityStats{}
+
+	actions := make([]*Action, 0, 100)
+	cond := builder.NewCond().
+		And(builder.Eq{"repo_id": repoID}).
+		And(builder.Gte{"created": timeFrom})
+	if err := x.Where(cond).Find(&actions); err != nil {
+		return nil, fmt.Errorf("find actions: %v", err)
+	}
+
+	commitsByAuthor := make(map[string]*ActivityAuthorCommits)
+	unresolvedIssues := make(map[int64]bool)
+	for _, a := range actions {
+		switch a.OpType {
+		case COMMIT_REPO, MIRROR_SYNC_PUSH:
+			payload, err := a.GetPayload()
+			if err != nil {
+				continue
+			}
+			push, ok := payload.(*PushPayload)
+			if !ok {
+				continue
+			}
+			for _, c := range push.Commits {
+				ac := commitsByAuthor[c.AuthorEmail]
+				if ac == nil {
+					ac = &ActivityAuthorCommits{AuthorEmail: c.AuthorEmail, AuthorName: c.AuthorName}
+					commitsByAuthor[c.AuthorEmail] = ac
+				}
+				ac.Commits++
+			}
+
+		case PUSH_TAG, MIRROR_SYNC_CREATE:
+			if a.GetRefType() == "tag" {
+				stats.NewReleaseCount++
+			}
+
+		case CREATE_ISSUE:
+			stats.OpenedIssueCount++
+
+		case COMMENT_ISSUE:
+			if issue, err := commentedIssue(a); err == nil && !issue.IsClosed && !unresolvedIssues[issue.ID] {
+				unresolvedIssues[issue.ID] = true
+				stats.UnresolvedIssueCount++
+			}
+
+		case CREATE_PULL_REQUEST:
+			stats.OpenedPullRequestCount++
+
+		case MERGE_PULL_REQUEST:
+			stats.MergedPullRequestCount++
+
+		case REJECT_PULL_REQUEST:
+			stats.RejectedPullRequestCount++
+		}
+	}
+
+	stats.ActiveCommits = make([]*ActivityAuthorCommits, 0, len(commitsByAuthor))
+	for _, ac := range commitsByAuthor {
+		stats.ActiveCommits = append(stats.ActiveCommits, ac)
+	}
+	sort.Slice(stats.ActiveCommits, func(i, j int) bool {
+		return stats.ActiveCommits[i].Commits > stats.ActiveCommits[j].Commits
+	})
+
+	// Closings aren't recorded as an Action, so count them straight from the
+	// Issue table instead. UpdatedUnix is the closest thing to a "closed at"
+	// we have; it's only exact as long as nothing else touches a closed
+	// issue afterwards, which is good enough for a dashboard count.
+	closedCount, err := x.Where("repo_id = ? AND is_closed = ? AND updated_unix >= ?", repoID, true, timeFrom.Unix()).Count(new(Issue))
+	if err != nil {
+		return nil, fmt.Errorf("count closed issues: %v", err)
+	}
+	stats.ClosedIssueCount = closedCount
+
+	if windows == nil {
+		windows = make(map[int64]*ActivityStats)
+	}
+	windows[timeFrom.Unix()] = stats
+	if err := cache.Put(key, windows, repoActivityCacheTTL); err != nil {
+		log.Error(4, "GetRepoActivityStats: cache.Put repo %d: %v", repoID, err)
+	}
+
+	return stats, nil
+}
+
+// commentedIssue resolves the issue a COMMENT_ISSUE action refers to.
+func commentedIssue(a *Action) (*Issue, error) {
+	index := com.StrTo(a.GetIssueInfos()[0]).MustInt64()
+	return GetIssueByIndex(a.RepoID, index)
+}
+
+// WriteCSV renders stats as a flat CSV report: a header row and one summary
+// row, then a second header row followed by one row per contributing
+// author, for the "CSV export mode" of the activity endpoint.
+func (stats *ActivityStats) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	defer cw.Flush()
+
+	if err := cw.Write([]string{
+		"opened_issues", "closed_issues", "unresolved_issues",
+		"opened_pull_requests", "merged_pull_requests", "rejected_pull_requests",
+		"new_release_tags",
+	}); err != nil {
+		return err
+	}
+	if err := cw.Write([]string{
+		fmt.Sprintf("%d", stats.OpenedIssueCount),
+		fmt.Sprintf("%d", stats.ClosedIssueCount),
+		fmt.Sprintf("%d", stats.UnresolvedIssueCount),
+		fmt.Sprintf("%d", stats.OpenedPullRequestCount),
+		fmt.Sprintf("%d", stats.MergedPullRequestCount),
+		fmt.Sprintf("%d", stats.RejectedPullRequestCount),
+		fmt.Sprintf("%d", stats.NewReleaseCount),
+	}); err != nil {
+		return err
+	}
+
+	if err := cw.Write([]string{"author_name", "author_email", "commits"}); err != nil {
+		return err
+	}
+	for _, ac := range stats.ActiveCommits {
+		if err := cw.Write([]string{ac.AuthorName, ac.AuthorEmail, fmt.Sprintf("%d", ac.Commits)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}